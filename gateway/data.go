@@ -0,0 +1,71 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+
+	"gateway/lorawan"
+)
+
+// parseDataUplink parses a data uplink PHYPayload, validates its frame counter
+// against the device's replay window, dispatches any MAC commands carried in
+// FOpts or FPort 0, and decrypts application FRMPayload into readings.
+func (g *Gateway) parseDataUplink(ctx context.Context, payload []byte, freqHz uint32, dr int, bandwidth byte, snr float64) (string, map[string]interface{}, error) {
+	du, err := lorawan.ParseDataUplink(payload)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// the device lookup and replay window update both read/mutate device state
+	// shared with handleMACCommands, handleDownlinkAck, and the session flush -
+	// hold g.mu across them.
+	g.mu.Lock()
+	devices := make([]*lorawan.Device, 0, len(g.devices))
+	for _, dev := range g.devices {
+		devices = append(devices, dev)
+	}
+
+	device := lorawan.FindDeviceByAddr(devices, du.DevAddr)
+	if device == nil {
+		g.mu.Unlock()
+		return "", nil, lorawan.ErrNoDevice
+	}
+
+	fCntUp, err := lorawan.CheckReplay(device, du.FCnt)
+	if err == nil {
+		device.LastUplinkFreqHz = freqHz
+		device.LastUplinkDR = dr
+		device.LastUplinkBandwidth = bandwidth
+	}
+	g.mu.Unlock()
+	if err != nil {
+		return device.Name, nil, err
+	}
+
+	if du.FCtrl&lorawan.AckBit != 0 {
+		g.handleDownlinkAck(device)
+	}
+
+	if len(du.FOpts) > 0 {
+		g.handleMACCommands(device, du.FOpts, snr)
+	}
+
+	var readings map[string]interface{}
+	if len(du.FRMPayload) > 0 {
+		decrypted, err := lorawan.DecryptFRMPayload(device, du.FPort, fCntUp, du.FRMPayload)
+		if err != nil {
+			return device.Name, nil, fmt.Errorf("failed to decrypt FRMPayload: %w", err)
+		}
+
+		if du.FPort == 0 {
+			// FPort 0 carries MAC commands in FRMPayload instead of application data.
+			g.handleMACCommands(device, decrypted, snr)
+		} else {
+			readings = map[string]interface{}{
+				"payload": decrypted,
+			}
+		}
+	}
+
+	return device.Name, readings, nil
+}
@@ -0,0 +1,170 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gateway/hal"
+	"gateway/lorawan"
+
+	"go.viam.com/utils"
+)
+
+// rx1DelaySec and rx2DelaySec are the delays after an uplink before the RX1
+// and RX2 windows open, per the LoRaWAN spec.
+const (
+	rx1DelaySec = 1
+	rx2DelaySec = 2
+)
+
+// SendDownlink queues payload to be sent to device on fPort, on the next RX1/RX2
+// window following an uplink from that device. If confirmed is true, the downlink
+// is re-sent on subsequent uplinks until the device ACKs it or lorawan.MaxDownlinkRetries
+// is reached. A device holds one pending downlink at a time: calling SendDownlink
+// again replaces any downlink not yet sent or, if confirmed, not yet ACKed.
+func (g *Gateway) SendDownlink(ctx context.Context, deviceName string, fPort byte, payload []byte, confirmed bool) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	device, ok := g.devices[deviceName]
+	if !ok {
+		return fmt.Errorf("device %s not found", deviceName)
+	}
+
+	device.Pending = &lorawan.PendingDownlink{
+		FPort:     fPort,
+		Payload:   payload,
+		Confirmed: confirmed,
+	}
+
+	return nil
+}
+
+// sendPendingDownlink transmits the queued downlink for deviceName, if any, on
+// RX1 of the uplink's frequency/DR, falling back to the RX2 parameters used for
+// join accepts. A confirmed downlink's FCntDown is only advanced once the
+// matching ACK bit is observed on a later uplink.
+func (g *Gateway) sendPendingDownlink(ctx context.Context, deviceName string) error {
+	g.mu.Lock()
+	device, ok := g.devices[deviceName]
+	if !ok {
+		g.mu.Unlock()
+		return nil
+	}
+	dl := device.Pending
+	if dl == nil && len(device.MACAnswers) > 0 {
+		// no application downlink is queued, but there are MAC answers waiting -
+		// send a downlink with no FPort or FRMPayload, carrying just the FOpts.
+		dl = &lorawan.PendingDownlink{}
+	}
+	g.mu.Unlock()
+
+	if dl == nil {
+		return nil
+	}
+
+	mhdr := byte(lorawan.MTypeUnconfirmedDataDown)
+	if dl.Confirmed {
+		mhdr = lorawan.MTypeConfirmedDataDown
+	}
+
+	// RX1 opens rx1DelaySec after the uplink, on the uplink's frequency/DR. If we
+	// never recorded an uplink freq/DR for this device, or sending on RX1 fails,
+	// fall back to the RX2 parameters used for join accepts, rx2DelaySec after
+	// the uplink.
+	if ok := utils.SelectContextOrWait(ctx, rx1DelaySec*time.Second); !ok {
+		return ctx.Err()
+	}
+
+	g.mu.Lock()
+	phyPayload, err := lorawan.BuildDataDownlink(device, dl, mhdr)
+	g.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	_, rx2SF, rx2Bandwidth := g.region.RX2Params()
+
+	if device.LastUplinkFreqHz != 0 {
+		rx1Freq, rx1DR := g.region.RX1Params(device.LastUplinkFreqHz, device.LastUplinkDR)
+		// RX1 must reply on the uplink channel's bandwidth, not RX2's - with the
+		// AS923/US915/AU915 RX2 params those differ (125k vs 500k) and the device
+		// will never hear a downlink sent on the wrong one.
+		// lock so there is not two sends at the same time.
+		g.mu.Lock()
+		err := g.radio.Send(hal.TxPacket{
+			FreqHz:     rx1Freq,
+			SF:         rx1DR,
+			Bandwidth:  device.LastUplinkBandwidth,
+			InvertPol:  true, // Downlinks are always reverse polarity.
+			TXPowerDBm: 26,
+			Payload:    phyPayload,
+		})
+		g.mu.Unlock()
+		if err == nil {
+			g.finishDownlink(device, dl, deviceName)
+			return nil
+		}
+		g.logger.Warnf("failed to send downlink to %s on RX1, falling back to RX2: %s", deviceName, err)
+	}
+
+	if ok := utils.SelectContextOrWait(ctx, (rx2DelaySec-rx1DelaySec)*time.Second); !ok {
+		return ctx.Err()
+	}
+
+	rx2Freq, _, _ := g.region.RX2Params()
+	g.mu.Lock()
+	err = g.radio.Send(hal.TxPacket{
+		FreqHz:     rx2Freq,
+		SF:         rx2SF,
+		Bandwidth:  rx2Bandwidth,
+		InvertPol:  true, // Downlinks are always reverse polarity.
+		TXPowerDBm: 26,
+		Payload:    phyPayload,
+	})
+	g.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send downlink packet: %w", err)
+	}
+
+	g.finishDownlink(device, dl, deviceName)
+	return nil
+}
+
+// finishDownlink advances FCntDown for unconfirmed downlinks and drops the
+// queue entry, or counts a transmission and drops a confirmed downlink that
+// has reached lorawan.MaxDownlinkRetries.
+func (g *Gateway) finishDownlink(device *lorawan.Device, dl *lorawan.PendingDownlink, deviceName string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !dl.Confirmed {
+		// unconfirmed downlinks are fire-and-forget - advance FCntDown now that
+		// the packet has been handed to the radio.
+		device.FCntDown++
+		device.Pending = nil
+		return
+	}
+
+	// confirmed downlinks must reuse the same FCntDown on every retransmission
+	// so the device can recognize and dedup them - it only advances once the
+	// device's ACK is observed in handleDownlinkAck.
+	dl.Retries++
+	if dl.Retries >= lorawan.MaxDownlinkRetries {
+		g.logger.Warnf("confirmed downlink to %s exceeded max retries, dropping", deviceName)
+		device.Pending = nil
+	}
+}
+
+// handleDownlinkAck clears the pending confirmed downlink for a device and
+// advances FCntDown once its ACK bit is observed in a subsequent uplink.
+func (g *Gateway) handleDownlinkAck(device *lorawan.Device) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if device.Pending != nil && device.Pending.Confirmed {
+		device.FCntDown++
+		device.Pending = nil
+	}
+}
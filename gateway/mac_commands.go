@@ -0,0 +1,23 @@
+package gateway
+
+import "gateway/lorawan"
+
+// handleMACCommands dispatches the MAC commands in data, queuing any answers
+// on device's next downlink, and exposes a reported device status in its
+// readings.
+func (g *Gateway) handleMACCommands(device *lorawan.Device, data []byte, snr float64) {
+	g.mu.Lock()
+	status, err := lorawan.HandleMACCommands(device, data, snr)
+	g.mu.Unlock()
+	if err != nil {
+		g.logger.Warnf("error handling MAC commands from %s: %s", device.Name, err)
+		return
+	}
+
+	if status != nil {
+		g.updateReadings(device.Name, map[string]interface{}{
+			"battery": status.Battery,
+			"margin":  status.Margin,
+		})
+	}
+}
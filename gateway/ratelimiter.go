@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultJoinRateLimit and defaultJoinRateBurst are used when a Config does
+	// not set JoinRatePerSec/JoinRateBurst.
+	defaultJoinRateLimit = 5
+	defaultJoinRateBurst = 10
+
+	rateLimiterGCInterval  = time.Minute
+	rateLimiterIdleTimeout = 5 * time.Minute
+)
+
+// tokenBucket is a simple token-bucket rate limiter, refilled continuously at
+// refillRate tokens per second up to maxTokens.
+type tokenBucket struct {
+	mu sync.Mutex
+
+	tokens     float64
+	maxTokens  float64
+	refillRate float64
+
+	lastRefill time.Time
+	lastSeen   time.Time
+}
+
+func newTokenBucket(refillRate float64, burst int) *tokenBucket {
+	now := time.Now()
+	return &tokenBucket{
+		tokens:     float64(burst),
+		maxTokens:  float64(burst),
+		refillRate: refillRate,
+		lastRefill: now,
+		lastSeen:   now,
+	}
+}
+
+// allow reports whether a token is available, consuming one if so.
+func (b *tokenBucket) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.lastRefill).Seconds() * b.refillRate
+	if b.tokens > b.maxTokens {
+		b.tokens = b.maxTokens
+	}
+	b.lastRefill = now
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// idleSince reports whether the bucket has not been used since cutoff.
+func (b *tokenBucket) idleSince(cutoff time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastSeen.Before(cutoff)
+}
+
+// joinRateLimiter rate limits join requests per DevEUI and globally, modeled
+// on WireGuard's token-bucket ratelimiter.go, to keep OTAA flooding from
+// exhausting the AES work and worker pool behind handleJoin.
+type joinRateLimiter struct {
+	mu       sync.Mutex
+	byDevEUI map[string]*tokenBucket
+
+	global *tokenBucket
+
+	rate  float64
+	burst int
+}
+
+func newJoinRateLimiter(rate float64, burst int) *joinRateLimiter {
+	return &joinRateLimiter{
+		byDevEUI: make(map[string]*tokenBucket),
+		global:   newTokenBucket(rate, burst),
+		rate:     rate,
+		burst:    burst,
+	}
+}
+
+// allow reports whether a join request from devEUI should be processed,
+// consuming a token from both the global bucket and the per-DevEUI bucket.
+func (l *joinRateLimiter) allow(devEUI []byte) bool {
+	if !l.global.allow() {
+		return false
+	}
+
+	key := string(devEUI)
+
+	l.mu.Lock()
+	bucket, ok := l.byDevEUI[key]
+	if !ok {
+		bucket = newTokenBucket(l.rate, l.burst)
+		l.byDevEUI[key] = bucket
+	}
+	l.mu.Unlock()
+
+	return bucket.allow()
+}
+
+// garbageCollect periodically removes per-DevEUI buckets that have been idle
+// for longer than rateLimiterIdleTimeout, so that fuzzed/spoofed DevEUIs don't
+// grow the map without bound.
+func (l *joinRateLimiter) garbageCollect(ctx context.Context) {
+	ticker := time.NewTicker(rateLimiterGCInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			cutoff := time.Now().Add(-rateLimiterIdleTimeout)
+			l.mu.Lock()
+			for key, bucket := range l.byDevEUI {
+				if bucket.idleSince(cutoff) {
+					delete(l.byDevEUI, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}
+}
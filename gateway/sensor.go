@@ -1,20 +1,16 @@
+// Package gateway wires the sx1302 radio (package hal) and LoRaWAN protocol
+// logic (package lorawan) into a Viam sensor.Sensor component.
 package gateway
 
-/*
-#cgo CFLAGS: -I./sx1302/libloragw/inc -I./sx1302/libtools/inc
-#cgo LDFLAGS: -L./sx1302/libloragw -lloragw -L./sx1302/libtools -lbase64 -lparson -ltinymt32  -lm
-
-#include "../sx1302/libloragw/inc/loragw_hal.h"
-#include "gateway.h"
-#include <stdlib.h>
-
-*/
-import "C"
 import (
 	"context"
 	"encoding/hex"
 	"errors"
+	"fmt"
 	"gateway/gpio"
+	"gateway/hal"
+	"gateway/lorawan"
+	"gateway/session"
 	"sync"
 	"time"
 
@@ -31,6 +27,19 @@ var Model = resource.NewModel("viam", "lorawan", "sx1302-gateway")
 // Config describes the configuration of the gateway
 type Config struct {
 	Devices []DeviceConfig `json:"devices"`
+
+	// JoinRatePerSec and JoinRateBurst configure the per-DevEUI and global join
+	// request rate limiter. Both default to a conservative value if unset.
+	JoinRatePerSec float64 `json:"join_rate_per_sec,omitempty"`
+	JoinRateBurst  int     `json:"join_rate_burst,omitempty"`
+
+	// SessionPath is the file device session state (DevAddr, session keys, frame
+	// counters) is persisted to across restarts. Defaults to session.DefaultPath.
+	SessionPath string `json:"session_path,omitempty"`
+
+	// Region selects the LoRaWAN regional parameters (channel plan, RX1/RX2
+	// windows, TX power table). Defaults to AS923.
+	Region string `json:"region,omitempty"`
 }
 
 type DeviceConfig struct {
@@ -44,18 +53,6 @@ type DeviceConfig struct {
 	AppKey      string `json:"app_key,omitempty"`
 }
 
-type Device struct {
-	name        string
-	decoderPath string
-
-	nwkSKey types.AES128Key
-	appSKey types.AES128Key
-	AppKey  types.AES128Key
-
-	addr   []byte
-	devEui []byte
-}
-
 func init() {
 	resource.RegisterComponent(
 		sensor.API,
@@ -67,6 +64,18 @@ func init() {
 
 // Validate ensures all parts of the config are valid.
 func (conf *Config) Validate(path string) ([]string, error) {
+	if conf.JoinRatePerSec < 0 {
+		return nil, resource.NewConfigValidationError(path,
+			errors.New("join_rate_per_sec must not be negative"))
+	}
+	if conf.JoinRateBurst < 0 {
+		return nil, resource.NewConfigValidationError(path,
+			errors.New("join_rate_burst must not be negative"))
+	}
+	if _, err := lorawan.RegionFromName(conf.Region); err != nil {
+		return nil, resource.NewConfigValidationError(path, err)
+	}
+
 	for _, d := range conf.Devices {
 		if d.DecoderPath == "" {
 			return nil, resource.NewConfigValidationError(path,
@@ -141,14 +150,18 @@ type Gateway struct {
 	resource.AlwaysRebuild
 	logger logging.Logger
 
+	radio   hal.Radio
 	workers *utils.StoppableWorkers
 	mu      sync.Mutex
 
 	lastReadings map[string]interface{} // map of devices to readings
 	readingsMu   sync.Mutex
 
-	devices map[string]*Device // map of name to devices
+	devices map[string]*lorawan.Device // map of name to devices
 
+	joinLimiter  *joinRateLimiter
+	sessionStore session.Store
+	region       lorawan.RegionalParameters
 }
 
 func newGateway(
@@ -162,27 +175,33 @@ func newGateway(
 		return nil, err
 	}
 
+	region, err := lorawan.RegionFromName(cfg.Region)
+	if err != nil {
+		return nil, err
+	}
+
 	g := &Gateway{
 		Named:        conf.ResourceName().AsNamed(),
 		logger:       logger,
 		lastReadings: map[string]interface{}{},
+		region:       region,
+		radio:        hal.NewSX1302Radio(),
 	}
 
-	// // Start and reset the radio
+	// Start and reset the radio
 	gpio.InitGPIO()
 	gpio.ResetGPIO()
 
-	errCode := C.setUpGateway(C.int(0))
-	if errCode != 0 {
-		return nil, errors.New("failed to start the gateway")
+	if err := g.radio.Start(); err != nil {
+		return nil, err
 	}
 
-	g.devices = make(map[string]*Device)
+	g.devices = make(map[string]*lorawan.Device)
 
 	for _, device := range cfg.Devices {
-		dev := &Device{
-			name:        device.Name,
-			decoderPath: device.DecoderPath,
+		dev := &lorawan.Device{
+			Name:        device.Name,
+			DecoderPath: device.DecoderPath,
 		}
 
 		switch device.JoinType {
@@ -197,33 +216,77 @@ func newGateway(
 			if err != nil {
 				return nil, err
 			}
-			dev.devEui = devEui
+			dev.DevEui = devEui
 		case "ABP":
 			devAddr, err := hex.DecodeString(device.DevAddr)
 			if err != nil {
 				return nil, err
 			}
 
-			dev.addr = devAddr
+			dev.Addr = devAddr
 
 			appSKey, err := hex.DecodeString(device.AppSKey)
 			if err != nil {
 				return nil, err
 			}
 
-			dev.appSKey = types.AES128Key(appSKey)
+			dev.AppSKey = types.AES128Key(appSKey)
+
+			nwkSKey, err := hex.DecodeString(device.NwkSKey)
+			if err != nil {
+				return nil, err
+			}
+
+			dev.NwkSKey = types.AES128Key(nwkSKey)
 		}
 		g.devices[device.Name] = dev
 	}
 
+	sessionPath := cfg.SessionPath
+	if sessionPath == "" {
+		sessionPath = session.DefaultPath
+	}
+	store, err := session.NewFileStore(sessionPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open session store: %w", err)
+	}
+	g.sessionStore = store
+
+	sessions, err := store.Load()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load session store: %w", err)
+	}
+	for _, dev := range g.devices {
+		state, ok := sessions[dev.Name]
+		if !ok {
+			continue
+		}
+		dev.Addr = state.DevAddr
+		dev.AppSKey = state.AppSKey
+		dev.NwkSKey = state.NwkSKey
+		dev.FCntUp = state.FCntUp
+		dev.FCntDown = state.FCntDown
+		dev.JoinNonces = state.JoinNonces
+	}
+
+	rate := cfg.JoinRatePerSec
+	if rate == 0 {
+		rate = defaultJoinRateLimit
+	}
+	burst := cfg.JoinRateBurst
+	if burst == 0 {
+		burst = defaultJoinRateBurst
+	}
+	g.joinLimiter = newJoinRateLimiter(rate, burst)
+
 	g.receivePackets()
+	g.workers.Add(g.joinLimiter.garbageCollect)
+	g.workers.Add(g.flushSessions)
 
 	return g, nil
 }
 
 func (g *Gateway) receivePackets() {
-	// receive the radio packets
-	packet := C.createRxPacketArray()
 	g.workers = utils.NewBackgroundStoppableWorkers(func(ctx context.Context) {
 		for {
 			select {
@@ -231,36 +294,45 @@ func (g *Gateway) receivePackets() {
 				return
 			default:
 			}
-			numPackets := int(C.receive(packet))
-			switch numPackets {
-			case 0:
+			packets, err := g.radio.Receive()
+			if err != nil {
+				g.logger.Errorf("error receiving lora packet: %s", err)
+				continue
+			}
+			if len(packets) == 0 {
 				// no packet received, wait 10 ms to receive again.
 				select {
 				case <-ctx.Done():
 					return
 				case <-time.After(10 * time.Millisecond):
 				}
-			case 1:
-				// received a LORA packet
-				var payload []byte
-				for i := 0; i < numPackets; i++ {
-					if packet.size == 0 {
-						continue
-					}
-					// Convert packet to go byte array
-					for i := 0; i < int(packet.size); i++ {
-						payload = append(payload, byte(packet.payload[i]))
-					}
-					g.handlePacket(ctx, payload)
-				}
-			default:
-				g.logger.Errorf("error receiving lora packet")
+				continue
+			}
+			for _, packet := range packets {
+				g.handlePacket(ctx, packet.Payload, packet.FreqHz, packet.DR, packet.Bandwidth, packet.SNR)
 			}
 		}
 	})
 }
 
-func (g *Gateway) handlePacket(ctx context.Context, payload []byte) {
+func (g *Gateway) handlePacket(ctx context.Context, payload []byte, freqHz uint32, dr int, bandwidth byte, snr float64) {
+	if len(payload) == 0 {
+		return
+	}
+
+	// Check the join rate limit before scheduling a worker, so that the 6 second
+	// RX2 sleep in handleJoin cannot be used to exhaust the worker pool.
+	if payload[0] == 0x0 {
+		if len(payload) < 23 {
+			g.logger.Warnf("received malformed join request")
+			return
+		}
+		if !g.joinLimiter.allow(payload[9:17]) {
+			g.logger.Debugf("dropping join request from %x - rate limit exceeded", payload[9:17])
+			return
+		}
+	}
+
 	g.workers.Add(func(ctx context.Context) {
 		// first byte is MHDR - specifies message type
 		switch payload[0] {
@@ -268,21 +340,35 @@ func (g *Gateway) handlePacket(ctx context.Context, payload []byte) {
 			g.logger.Infof("received join request")
 			err := g.handleJoin(ctx, payload)
 			if err != nil {
-				// don't log as error if it was a request from unknown device.
-				if errors.Is(errNoDevice, err) {
+				switch {
+				case errors.Is(err, lorawan.ErrNoDevice):
 					g.logger.Debug("received join request from unknown device - ignoring")
-					return
+				case errors.Is(err, lorawan.ErrReplayedJoin):
+					g.logger.Debugf("dropping replayed join request: %s", err)
+				default:
+					g.logger.Errorf("couldn't handle join request: %s", err)
 				}
-				g.logger.Errorf("couldn't handle join request: %w", err)
 			}
 		case 0x40:
 			g.logger.Infof("received data uplink")
-			name, readings, err := g.parseDataUplink(ctx, payload)
+			name, readings, err := g.parseDataUplink(ctx, payload, freqHz, dr, bandwidth, snr)
 			if err != nil {
-				g.logger.Errorf("error parsing uplink message: %w", err)
+				switch {
+				case errors.Is(err, lorawan.ErrNoDevice):
+					g.logger.Debug("received data uplink from unknown device - ignoring")
+				case errors.Is(err, lorawan.ErrReplayedFrame):
+					g.logger.Debugf("dropping replayed uplink: %s", err)
+				default:
+					g.logger.Errorf("error parsing uplink message: %s", err)
+				}
+				return
 			}
 			g.updateReadings(name, readings)
 
+			if err := g.sendPendingDownlink(ctx, name); err != nil {
+				g.logger.Errorf("error sending downlink to %s: %s", name, err)
+			}
+
 		default:
 			g.logger.Warnf("received unsupported packet type")
 		}
@@ -310,8 +396,10 @@ func (g *Gateway) updateReadings(name string, newReadings map[string]interface{}
 }
 func (g *Gateway) Close(ctx context.Context) error {
 	g.workers.Stop()
-	C.stopGateway()
-	return nil
+	if err := g.sessionStore.Close(); err != nil {
+		g.logger.Errorf("error closing session store: %s", err)
+	}
+	return g.radio.Stop()
 }
 
 func (g *Gateway) Readings(ctx context.Context, extra map[string]interface{}) (map[string]interface{}, error) {
@@ -319,3 +407,47 @@ func (g *Gateway) Readings(ctx context.Context, extra map[string]interface{}) (m
 	defer g.readingsMu.Unlock()
 	return g.lastReadings, nil
 }
+
+// DoCommand supports queuing a downlink for a device, since there is no native
+// downlink path on the sensor API. Expects:
+//
+//	{"command": "send_downlink", "device_name": "<name>", "fport": <int>,
+//	 "payload": "<hex>", "confirmed": <bool>}
+func (g *Gateway) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
+	name, ok := cmd["command"].(string)
+	if !ok {
+		return nil, errors.New("command is required")
+	}
+
+	switch name {
+	case "send_downlink":
+		deviceName, ok := cmd["device_name"].(string)
+		if !ok {
+			return nil, errors.New("device_name is required")
+		}
+
+		fportF, ok := cmd["fport"].(float64)
+		if !ok {
+			return nil, errors.New("fport is required")
+		}
+
+		payloadHex, ok := cmd["payload"].(string)
+		if !ok {
+			return nil, errors.New("payload is required")
+		}
+		payload, err := hex.DecodeString(payloadHex)
+		if err != nil {
+			return nil, fmt.Errorf("payload must be hex encoded: %w", err)
+		}
+
+		confirmed, _ := cmd["confirmed"].(bool)
+
+		if err := g.SendDownlink(ctx, deviceName, byte(fportF), payload, confirmed); err != nil {
+			return nil, err
+		}
+
+		return map[string]interface{}{"queued": true}, nil
+	default:
+		return nil, fmt.Errorf("unknown command %q", name)
+	}
+}
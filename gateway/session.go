@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"time"
+
+	"gateway/lorawan"
+	"gateway/session"
+)
+
+// sessionFlushInterval is how often in-memory counter updates are flushed to
+// the session.Store.
+const sessionFlushInterval = 30 * time.Second
+
+// flushSessions periodically writes each device's current session state to
+// the session.Store, batching counter updates instead of persisting on every
+// frame.
+func (g *Gateway) flushSessions(ctx context.Context) {
+	ticker := time.NewTicker(sessionFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.saveSessions()
+		}
+	}
+}
+
+func (g *Gateway) saveSessions() {
+	g.mu.Lock()
+	devices := make([]*lorawan.Device, 0, len(g.devices))
+	for _, dev := range g.devices {
+		devices = append(devices, dev)
+	}
+	g.mu.Unlock()
+
+	for _, dev := range devices {
+		if err := g.saveSession(dev); err != nil {
+			g.logger.Errorf("error persisting session for %s: %s", dev.Name, err)
+		}
+	}
+}
+
+// saveSession persists dev's session state, keyed on its configured name - both
+// OTAA and ABP devices always have one, unlike DevEUI, which ABP devices don't
+// configure.
+func (g *Gateway) saveSession(dev *lorawan.Device) error {
+	state := session.State{
+		DevAddr:    dev.Addr,
+		AppSKey:    dev.AppSKey,
+		NwkSKey:    dev.NwkSKey,
+		FCntUp:     dev.FCntUp,
+		FCntDown:   dev.FCntDown,
+		JoinNonces: dev.JoinNonces,
+		LastSeen:   time.Now(),
+	}
+	return g.sessionStore.Save(dev.Name, state)
+}
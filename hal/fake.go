@@ -0,0 +1,69 @@
+package hal
+
+import "sync"
+
+// FakeRadio is an in-memory Radio for unit testing package lorawan and
+// package gateway without the sx1302 HAL linked in. Uplinks are injected with
+// InjectUplink and transmitted packets can be inspected with Sent.
+type FakeRadio struct {
+	mu      sync.Mutex
+	pending []Packet
+	sent    []TxPacket
+	started bool
+	stopped bool
+}
+
+// NewFakeRadio returns a FakeRadio with no queued uplinks.
+func NewFakeRadio() *FakeRadio {
+	return &FakeRadio{}
+}
+
+func (r *FakeRadio) Start() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.started = true
+	return nil
+}
+
+// Receive returns, and clears, any packets queued by InjectUplink.
+func (r *FakeRadio) Receive() ([]Packet, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.pending) == 0 {
+		return nil, nil
+	}
+	packets := r.pending
+	r.pending = nil
+	return packets, nil
+}
+
+// Send records pkt so it can be inspected with Sent.
+func (r *FakeRadio) Send(pkt TxPacket) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sent = append(r.sent, pkt)
+	return nil
+}
+
+func (r *FakeRadio) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.stopped = true
+	return nil
+}
+
+// InjectUplink queues a packet to be returned from the next Receive call.
+func (r *FakeRadio) InjectUplink(payload []byte, freqHz uint32, dr int, bandwidth byte, snr float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pending = append(r.pending, Packet{Payload: payload, FreqHz: freqHz, DR: dr, Bandwidth: bandwidth, SNR: snr})
+}
+
+// Sent returns every packet handed to Send so far.
+func (r *FakeRadio) Sent() []TxPacket {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	out := make([]TxPacket, len(r.sent))
+	copy(out, r.sent)
+	return out
+}
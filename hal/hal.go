@@ -0,0 +1,43 @@
+// Package hal abstracts the sx1302 concentrator hardware behind a Radio
+// interface, so the protocol logic in package lorawan and the RDK wiring in
+// package gateway can be built and tested without the cgo HAL bindings linked
+// in - see FakeRadio.
+package hal
+
+// Packet is a received LoRa packet: the raw PHYPayload bytes, and the
+// frequency, data rate, bandwidth, and SNR it was received at.
+type Packet struct {
+	Payload   []byte
+	FreqHz    uint32
+	DR        int
+	Bandwidth byte
+	SNR       float64
+}
+
+// TxPacket is a packet to transmit on a fixed frequency, spreading factor,
+// and bandwidth.
+type TxPacket struct {
+	FreqHz     uint32
+	SF         int
+	Bandwidth  byte
+	InvertPol  bool
+	TXPowerDBm int
+	Payload    []byte
+}
+
+// Radio is the hardware interface a gateway implementation transmits and
+// receives LoRa packets through.
+type Radio interface {
+	// Start powers on and configures the concentrator.
+	Start() error
+
+	// Receive returns the packets received since the last call, or none
+	// without blocking if nothing has been received; callers poll it.
+	Receive() ([]Packet, error)
+
+	// Send transmits pkt immediately.
+	Send(pkt TxPacket) error
+
+	// Stop powers down the concentrator.
+	Stop() error
+}
@@ -0,0 +1,96 @@
+//go:build sx1302
+
+package hal
+
+/*
+#cgo CFLAGS: -I../sx1302/libloragw/inc -I../sx1302/libtools/inc
+#cgo LDFLAGS: -L../sx1302/libloragw -lloragw -L../sx1302/libtools -lbase64 -lparson -ltinymt32  -lm
+
+#include "../sx1302/libloragw/inc/loragw_hal.h"
+#include "gateway.h"
+#include <stdlib.h>
+
+*/
+import "C"
+import (
+	"errors"
+)
+
+// sx1302Radio implements Radio with the cgo bindings to the vendored sx1302
+// HAL library.
+type sx1302Radio struct {
+	rxPacket *C.struct_lgw_pkt_rx_s
+}
+
+// NewSX1302Radio returns a Radio backed by the sx1302 concentrator. It takes
+// no channel argument: Start always calls setUpGateway with 0.
+func NewSX1302Radio() Radio {
+	return &sx1302Radio{
+		rxPacket: C.createRxPacketArray(),
+	}
+}
+
+func (r *sx1302Radio) Start() error {
+	errCode := C.setUpGateway(C.int(0))
+	if errCode != 0 {
+		return errors.New("failed to start the gateway")
+	}
+	return nil
+}
+
+func (r *sx1302Radio) Receive() ([]Packet, error) {
+	numPackets := int(C.receive(r.rxPacket))
+	switch numPackets {
+	case 0:
+		return nil, nil
+	case 1:
+		if r.rxPacket.size == 0 {
+			return nil, nil
+		}
+		payload := make([]byte, int(r.rxPacket.size))
+		for i := range payload {
+			payload[i] = byte(r.rxPacket.payload[i])
+		}
+		return []Packet{{
+			Payload:   payload,
+			FreqHz:    uint32(r.rxPacket.freq_hz),
+			DR:        int(r.rxPacket.datarate),
+			Bandwidth: byte(r.rxPacket.bandwidth),
+			SNR:       float64(r.rxPacket.snr),
+		}}, nil
+	default:
+		return nil, errors.New("error receiving lora packet")
+	}
+}
+
+func (r *sx1302Radio) Send(pkt TxPacket) error {
+	txPkt := C.struct_lgw_pkt_tx_s{
+		freq_hz:    C.uint32_t(pkt.FreqHz),
+		tx_mode:    C.uint8_t(0), // immediate mode
+		rf_chain:   C.uint8_t(0),
+		rf_power:   C.int8_t(pkt.TXPowerDBm),
+		modulation: C.uint8_t(0x10), // LORA modulation
+		bandwidth:  C.uint8_t(pkt.Bandwidth),
+		datarate:   C.uint32_t(pkt.SF),
+		coderate:   C.uint8_t(0x01), // code rate 4/5
+		invert_pol: C.bool(pkt.InvertPol),
+		size:       C.uint16_t(len(pkt.Payload)),
+	}
+
+	var cPayload [256]C.uchar
+	for i, b := range pkt.Payload {
+		cPayload[i] = C.uchar(b)
+	}
+	txPkt.payload = cPayload
+
+	errCode := int(C.send(&txPkt))
+	if errCode != 0 {
+		return errors.New("failed to send packet")
+	}
+	return nil
+}
+
+func (r *sx1302Radio) Stop() error {
+	C.stopGateway()
+	return nil
+}
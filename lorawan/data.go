@@ -0,0 +1,84 @@
+package lorawan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/crypto"
+	"go.thethings.network/lorawan-stack/v3/pkg/types"
+)
+
+// DataUplink holds the fields parsed out of a data uplink PHYPayload.
+type DataUplink struct {
+	DevAddr    []byte
+	FCtrl      byte
+	FCnt       uint16
+	FOpts      []byte
+	FPort      byte
+	FRMPayload []byte
+}
+
+// payload of a data uplink consists of
+// | MHDR | DEV ADDR | FCTRL | FCNT |  FOPTS  | FPORT | FRMPAYLOAD | MIC  |
+// | 1 B  |    4 B   |  1 B  |  2 B | 0-15 B  |  0-1B |   0-N B    |  4 B |
+func ParseDataUplink(payload []byte) (DataUplink, error) {
+	if len(payload) < 12 {
+		return DataUplink{}, errors.New("data uplink payload too short")
+	}
+
+	// everything in the FHDR is little endian.
+	devAddr := ReverseByteArray(payload[1:5])
+
+	fCtrl := payload[5]
+	fOptsLen := int(fCtrl & 0x0F)
+	fCnt := uint16(payload[6]) | uint16(payload[7])<<8
+
+	offset := 8 + fOptsLen
+	if len(payload) < offset+4 {
+		return DataUplink{}, errors.New("data uplink payload too short for FOpts")
+	}
+
+	du := DataUplink{
+		DevAddr: devAddr,
+		FCtrl:   fCtrl,
+		FCnt:    fCnt,
+		FOpts:   payload[8:offset],
+	}
+
+	// FPort and FRMPayload are optional - only present if there is application data or
+	// FPort 0 MAC commands.
+	if len(payload) > offset+4 {
+		du.FPort = payload[offset]
+		du.FRMPayload = payload[offset+1 : len(payload)-4]
+	}
+
+	return du, nil
+}
+
+// FindDeviceByAddr returns the device whose stored Addr matches addr, or nil
+// if none match.
+func FindDeviceByAddr(devices []*Device, addr []byte) *Device {
+	for _, device := range devices {
+		if bytes.Equal(device.Addr, addr) {
+			return device
+		}
+	}
+	return nil
+}
+
+// DecryptFRMPayload decrypts an uplink's FRMPayload with AppSKey, or NwkSKey
+// when fPort is 0 (MAC commands), using fCntUp - the replay-checked, fully
+// expanded 32-bit frame counter.
+func DecryptFRMPayload(device *Device, fPort byte, fCntUp uint32, frmPayload []byte) ([]byte, error) {
+	key := device.AppSKey
+	if fPort == 0 {
+		key = device.NwkSKey
+	}
+
+	decrypted, err := crypto.EncryptUplink(key, types.DevAddr(device.Addr), fCntUp, frmPayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt FRMPayload: %w", err)
+	}
+	return decrypted, nil
+}
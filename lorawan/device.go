@@ -0,0 +1,62 @@
+// Package lorawan implements LoRaWAN join and data frame parsing, MIC and key
+// derivation, frame-counter replay protection, and MAC command handling. It
+// is pure Go so it can be unit tested without the sx1302 HAL linked in - see
+// package hal for the radio abstraction this is wired up behind.
+package lorawan
+
+import "go.thethings.network/lorawan-stack/v3/pkg/types"
+
+// Device holds a LoRaWAN end device's identity, session keys, and protocol
+// state: frame counters, the replay window, queued downlinks, and any
+// outstanding MAC command answers.
+type Device struct {
+	Name        string
+	DecoderPath string
+
+	NwkSKey types.AES128Key
+	AppSKey types.AES128Key
+	AppKey  types.AES128Key
+
+	Addr   []byte
+	DevEui []byte
+
+	FCntDown uint32
+	Pending  *PendingDownlink
+
+	// LastUplinkFreqHz, LastUplinkDR, and LastUplinkBandwidth are the
+	// frequency, data rate, and bandwidth of this device's most recently
+	// received uplink, used to derive its RX1 window - which must reply on
+	// the same bandwidth the uplink was received on.
+	LastUplinkFreqHz    uint32
+	LastUplinkDR        int
+	LastUplinkBandwidth byte
+
+	// FCntUp and ReplayBitmap form a WireGuard-style sliding anti-replay window
+	// over the last 64 frame counters accepted from this device.
+	FCntUp       uint32
+	ReplayBitmap uint64
+
+	// JoinNonces is the history of join nonces issued to this device, checked
+	// against on each join accept so a captured join accept can't be replayed.
+	JoinNonces []uint32
+
+	// SeenDevNonces is the history of DevNonces accepted from this device's
+	// join requests, checked against on each join request so a captured join
+	// request can't be replayed to force a fresh session.
+	SeenDevNonces []uint16
+
+	// MACAnswers holds pending MAC command answers (CID + payload, concatenated)
+	// to be piggybacked in the FOpts of this device's next downlink.
+	MACAnswers []byte
+}
+
+// ReverseByteArray returns a copy of arr with its bytes reversed, for
+// converting between the big- and little-endian field encodings used across
+// the LoRaWAN PHYPayload.
+func ReverseByteArray(arr []byte) []byte {
+	reversed := make([]byte, len(arr))
+	for i, j := 0, len(arr)-1; i < len(arr); i, j = i+1, j-1 {
+		reversed[i] = arr[j]
+	}
+	return reversed
+}
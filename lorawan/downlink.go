@@ -0,0 +1,80 @@
+package lorawan
+
+import (
+	"fmt"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/crypto"
+	"go.thethings.network/lorawan-stack/v3/pkg/types"
+)
+
+// MType values for data downlink frames.
+const (
+	MTypeUnconfirmedDataDown = 0x60
+	MTypeConfirmedDataDown   = 0xA0
+
+	// AckBit is set in FCtrl of a data downlink to ack a confirmed uplink, and
+	// in FCtrl of a data uplink to ack a confirmed downlink.
+	AckBit = 0x20
+
+	// MaxDownlinkRetries is the number of times a confirmed downlink is
+	// transmitted, counting the first transmission, before it is dropped.
+	MaxDownlinkRetries = 8
+)
+
+// PendingDownlink is a downlink queued for a device, sent on the RX1/RX2
+// window following the device's next uplink.
+type PendingDownlink struct {
+	FPort     byte
+	Payload   []byte
+	Confirmed bool
+	Retries   int
+}
+
+// BuildDataDownlink assembles and encrypts a data-down PHYPayload for device,
+// piggybacking any pending MAC command answers in FOpts. It consumes the
+// FOpts bytes it includes from device.MACAnswers.
+//
+// payload of a data downlink consists of
+// | MHDR | DEV ADDR | FCTRL | FCNT |  FOPTS  | FPORT | FRMPAYLOAD | MIC  |
+// | 1 B  |    4 B   |  1 B  |  2 B | 0-15 B  |  0-1B |   0-N B    |  4 B |
+func BuildDataDownlink(device *Device, dl *PendingDownlink, mhdr byte) ([]byte, error) {
+	// pending MAC command answers are piggybacked in FOpts - max 15 bytes.
+	fOpts := device.MACAnswers
+	if len(fOpts) > 15 {
+		fOpts = fOpts[:15]
+	}
+
+	addrLE := ReverseByteArray(device.Addr)
+
+	macPayload := make([]byte, 0, 7+len(fOpts)+1+len(dl.Payload))
+	macPayload = append(macPayload, addrLE...)
+	macPayload = append(macPayload, byte(len(fOpts))) // FCtrl: FOptsLen only - the ACK bit is never set.
+	macPayload = append(macPayload, byte(device.FCntDown), byte(device.FCntDown>>8))
+	macPayload = append(macPayload, fOpts...)
+
+	if len(dl.Payload) > 0 {
+		key := device.AppSKey
+		if dl.FPort == 0 {
+			key = device.NwkSKey
+		}
+
+		encrypted, err := crypto.EncryptDownlink(key, types.DevAddr(device.Addr), device.FCntDown, dl.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt downlink FRMPayload: %w", err)
+		}
+
+		macPayload = append(macPayload, dl.FPort)
+		macPayload = append(macPayload, encrypted...)
+	}
+
+	phyPayload := append([]byte{mhdr}, macPayload...)
+
+	mic, err := crypto.ComputeLegacyDownlinkMIC(device.NwkSKey, types.DevAddr(device.Addr), device.FCntDown, phyPayload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute downlink MIC: %w", err)
+	}
+
+	device.MACAnswers = device.MACAnswers[len(fOpts):]
+
+	return append(phyPayload, mic[:]...), nil
+}
@@ -0,0 +1,244 @@
+package lorawan
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/crypto"
+	"go.thethings.network/lorawan-stack/v3/pkg/crypto/cryptoservices"
+	"go.thethings.network/lorawan-stack/v3/pkg/ttnpb"
+	"go.thethings.network/lorawan-stack/v3/pkg/types"
+)
+
+// ErrNoDevice is returned when a packet's DevEUI or DevAddr does not match
+// any configured device.
+var ErrNoDevice = errors.New("received packet from unknown device")
+
+// ErrReplayedJoin is returned when a join request's DevNonce has already been
+// accepted from this device.
+var ErrReplayedJoin = errors.New("join request DevNonce replay detected")
+
+// NetID identifies the network to the device.
+var NetID = []byte{1, 2, 3}
+
+type JoinRequest struct {
+	joinEUI  []byte
+	devEUI   []byte
+	devNonce []byte
+	mic      []byte
+}
+
+// payload of join request consists of
+// | MHDR | JOIN EUI | DEV EUI  |   DEV NONCE  | MIC   |
+// | 1 B  |   8 B    |    8 B   |     2 B      |  4 B  |
+func ParseJoinRequest(payload []byte, devices []*Device) (JoinRequest, *Device, error) {
+	if len(payload) < 23 {
+		return JoinRequest{}, nil, errors.New("join request payload too short")
+	}
+
+	// everything in the join request payload is little endian
+	jr := JoinRequest{
+		joinEUI:  payload[1:9],
+		devEUI:   payload[9:17],
+		devNonce: payload[17:19],
+		mic:      payload[19:23],
+	}
+
+	// device.DevEui is in big endian - reverse to compare and find device.
+	devEUIBE := ReverseByteArray(jr.devEUI)
+
+	var matched *Device
+	for _, device := range devices {
+		if bytes.Equal(device.DevEui, devEUIBE) {
+			matched = device
+			break
+		}
+	}
+	if matched == nil {
+		return JoinRequest{}, nil, ErrNoDevice
+	}
+
+	if err := validateMIC(matched.AppKey, payload); err != nil {
+		return JoinRequest{}, nil, err
+	}
+
+	// DevNonce is little endian on air.
+	devNonce := uint16(jr.devNonce[0]) | uint16(jr.devNonce[1])<<8
+	for _, seen := range matched.SeenDevNonces {
+		if seen == devNonce {
+			return JoinRequest{}, nil, fmt.Errorf("DevNonce %d: %w", devNonce, ErrReplayedJoin)
+		}
+	}
+	matched.SeenDevNonces = append(matched.SeenDevNonces, devNonce)
+
+	return jr, matched, nil
+}
+
+// Format of Join Accept message:
+// | MHDR | JOIN NONCE | NETID |   DEV ADDR  | DL | RX DELAY |   CFLIST   | MIC  |
+// | 1 B  |     3 B    |   3 B |     4 B     | 1B |    1B    |  0 or 16   | 4 B  |
+func GenerateJoinAccept(ctx context.Context, device *Device, jr JoinRequest, region RegionalParameters) ([]byte, error) {
+	// generate a join nonce that hasn't been issued to this device before, so a
+	// captured join accept can't be replayed to re-derive the same session.
+	jn := generateUniqueJoinNonce(device)
+
+	// generate a random device address to identify uplinks.
+	device.Addr = generateDevAddr()
+
+	// starting a new session resets the frame counters and any queued downlink.
+	device.FCntUp = 0
+	device.FCntDown = 0
+	device.ReplayBitmap = 0
+	device.Pending = nil
+
+	// the join accept payload needs everything to be LE, so reverse the BE fields.
+	netIDLE := ReverseByteArray(NetID)
+	jnLE := ReverseByteArray(jn)
+	dAddrLE := ReverseByteArray(device.Addr)
+
+	payload := make([]byte, 0)
+	payload = append(payload, 0x20)
+	payload = append(payload, jnLE...)
+	payload = append(payload, netIDLE...)
+	payload = append(payload, dAddrLE...)
+	payload = append(payload, 0x00) // dl settings: default
+	payload = append(payload, 0x01) // rx delay: 1 second
+
+	// include the region's CFList, if it defines one - 16 extra bytes before the MIC.
+	if cfList := region.JoinAcceptCFList(); cfList != nil {
+		payload = append(payload, cfList...)
+	}
+
+	// generate MIC
+	resMIC, err := crypto.ComputeLegacyJoinAcceptMIC(device.AppKey, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	// everything but the mhdr needs to be encrypted.
+	payload = payload[1:]
+	payload = append(payload, resMIC[:]...)
+
+	enc, err := crypto.EncryptJoinAccept(device.AppKey, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	ja := make([]byte, 0)
+	// add back mhdr
+	ja = append(ja, 0x20)
+	ja = append(ja, enc...)
+
+	// generate the session keys
+	appsKey, nwkSKey, err := generateKeys(ctx, device.AppKey, jr.devNonce, jr.joinEUI, jn, jr.devEUI, NetID)
+	if err != nil {
+		return nil, err
+	}
+
+	device.AppSKey = appsKey
+	device.NwkSKey = nwkSKey
+
+	// return the encrypted join accept message
+	return ja, nil
+}
+
+// generateUniqueJoinNonce generates a random join nonce, retrying on the rare
+// chance of a collision with one already issued to this device.
+func generateUniqueJoinNonce(device *Device) []byte {
+	for {
+		jn := generateJoinNonce()
+		val := uint32(jn[0])<<16 | uint32(jn[1])<<8 | uint32(jn[2])
+
+		seen := false
+		for _, used := range device.JoinNonces {
+			if used == val {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			device.JoinNonces = append(device.JoinNonces, val)
+			return jn
+		}
+	}
+}
+
+func generateDevAddr() []byte {
+	source := rand.NewSource(time.Now().UnixNano())
+	rand := rand.New(source)
+
+	num1 := rand.Intn(255)
+	num2 := rand.Intn(255)
+
+	// first 7 MSB of devAddr is the network ID.
+	return []byte{1, 2, byte(num1), byte(num2)}
+}
+
+func validateMIC(appKey types.AES128Key, payload []byte) error {
+	mic, err := crypto.ComputeJoinRequestMIC(appKey, payload[:19])
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(payload[19:], mic[:]) {
+		return errors.New("invalid MIC")
+	}
+	return nil
+}
+
+func generateKeys(ctx context.Context, appKey types.AES128Key, devNonce, joinEUI, jn, devEUI, networkID []byte) (types.AES128Key, types.AES128Key, error) {
+	cryptoDev := &ttnpb.EndDevice{
+		Ids: &ttnpb.EndDeviceIdentifiers{JoinEui: joinEUI, DevEui: devEUI},
+	}
+
+	// TTN expects big endian dev nonce
+	devNonceBE := ReverseByteArray(devNonce)
+
+	// MAC V1.0.x devices only have a single root AppKey - NwkSKey and AppSKey
+	// are derived from it with different derivation constants.
+	networkCryptoService := cryptoservices.NewMemory(&appKey, nil)
+	nwkSKey, err := networkCryptoService.DeriveNwkSKey(
+		ctx,
+		cryptoDev,
+		ttnpb.MACVersion_MAC_V1_0_3,
+		types.JoinNonce(jn),
+		types.DevNonce(devNonceBE),
+		types.NetID(networkID),
+	)
+	if err != nil {
+		return types.AES128Key{}, types.AES128Key{}, fmt.Errorf("failed to generate NwkSKey: %w", err)
+	}
+
+	applicationCryptoService := cryptoservices.NewMemory(nil, &appKey)
+
+	// generate the appSKey!
+	// all inputs here are big endian.
+	appsKey, err := applicationCryptoService.DeriveAppSKey(
+		ctx,
+		cryptoDev,
+		ttnpb.MACVersion_MAC_V1_0_3,
+		types.JoinNonce(jn),
+		types.DevNonce(devNonceBE),
+		types.NetID(networkID),
+	)
+	if err != nil {
+		return types.AES128Key{}, types.AES128Key{}, fmt.Errorf("failed to generate AppSKey: %w", err)
+	}
+
+	return appsKey, nwkSKey, nil
+}
+
+func generateJoinNonce() []byte {
+	source := rand.NewSource(time.Now().UnixNano())
+	rand := rand.New(source)
+
+	num1 := rand.Intn(255)
+	num2 := rand.Intn(255)
+	num3 := rand.Intn(255)
+
+	return []byte{byte(num1), byte(num2), byte(num3)}
+}
@@ -0,0 +1,112 @@
+package lorawan
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// MAC command CIDs used by this gateway. Uplink and downlink commands share a
+// CID per the LoRaWAN spec, e.g. 0x02 is LinkCheckReq uplink / LinkCheckAns
+// downlink.
+const (
+	cidLinkCheck  = 0x02
+	cidLinkADR    = 0x03
+	cidDevStatus  = 0x06
+	cidDeviceTime = 0x0D
+)
+
+// gpsEpoch is the GPS/LoRaWAN epoch, used to compute DeviceTimeAns.
+var gpsEpoch = time.Date(1980, time.January, 6, 0, 0, 0, 0, time.UTC)
+
+// DeviceStatus is the battery level and demodulation margin last reported by
+// a device in a DevStatusAns.
+type DeviceStatus struct {
+	Battery byte
+	Margin  int8
+}
+
+// HandleMACCommands parses a run of MAC commands - either the FOpts field or,
+// for FPort 0, the decrypted FRMPayload - dispatches each by CID, appends any
+// answer to device.MACAnswers to be piggybacked on its next downlink, and
+// returns the device status if a DevStatusAns was seen.
+func HandleMACCommands(device *Device, data []byte, snr float64) (*DeviceStatus, error) {
+	var status *DeviceStatus
+
+	for i := 0; i < len(data); {
+		cid := data[i]
+		i++
+
+		consumed, answer, s, err := dispatchMACCommand(cid, data[i:], snr)
+		if err != nil {
+			return status, fmt.Errorf("error handling MAC command 0x%02x: %w", cid, err)
+		}
+		i += consumed
+
+		if answer != nil {
+			device.MACAnswers = append(device.MACAnswers, answer...)
+		}
+		if s != nil {
+			status = s
+		}
+	}
+
+	return status, nil
+}
+
+// dispatchMACCommand handles a single MAC command whose CID byte has already
+// been consumed, returning how many further bytes of rest it consumed, the
+// answer to queue (if any), and the device status reported (if any).
+func dispatchMACCommand(cid byte, rest []byte, snr float64) (int, []byte, *DeviceStatus, error) {
+	switch cid {
+	case cidLinkCheck:
+		// LinkCheckReq has no payload - answer with the demodulation margin
+		// derived from this uplink's SNR and a gateway count of 1.
+		return 0, []byte{cidLinkCheck, snrToMargin(snr), 1}, nil, nil
+
+	case cidLinkADR:
+		if len(rest) < 1 {
+			return 0, nil, nil, fmt.Errorf("LinkADRAns payload too short")
+		}
+		// the ack bits aren't acted on yet - just consume the answer.
+		return 1, nil, nil, nil
+
+	case cidDevStatus:
+		if len(rest) < 2 {
+			return 0, nil, nil, fmt.Errorf("DevStatusAns payload too short")
+		}
+		return 2, nil, &DeviceStatus{Battery: rest[0], Margin: int8(rest[1])}, nil
+
+	case cidDeviceTime:
+		return 0, deviceTimeAns(), nil, nil
+
+	default:
+		return 0, nil, nil, fmt.Errorf("unsupported MAC command CID 0x%02x", cid)
+	}
+}
+
+// deviceTimeAns builds a DeviceTimeAns: CID, seconds since the GPS epoch (LE),
+// and a fractional-second byte.
+func deviceTimeAns() []byte {
+	since := time.Since(gpsEpoch)
+
+	answer := make([]byte, 6)
+	answer[0] = cidDeviceTime
+	binary.LittleEndian.PutUint32(answer[1:5], uint32(since/time.Second))
+	answer[5] = byte((since % time.Second) * 256 / time.Second)
+	return answer
+}
+
+// snrToMargin converts an uplink's SNR into the 0-254 demodulation margin
+// reported in a LinkCheckAns.
+func snrToMargin(snr float64) byte {
+	margin := snr + 10
+	switch {
+	case margin < 0:
+		return 0
+	case margin > 254:
+		return 254
+	default:
+		return byte(margin)
+	}
+}
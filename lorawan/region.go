@@ -0,0 +1,156 @@
+package lorawan
+
+import "fmt"
+
+// RegionalParameters abstracts the per-region LoRaWAN channel plan: RX1/RX2
+// frequency and data rate derivation, the join-accept CFList, TX power table,
+// and default channel mask. Each region's constants come from the LoRaWAN
+// Regional Parameters specification.
+type RegionalParameters interface {
+	// Name returns the region identifier, e.g. "US915".
+	Name() string
+
+	// JoinAcceptCFList returns the 16-byte CFList to append to a join accept
+	// for this region, or nil if the region doesn't define one.
+	JoinAcceptCFList() []byte
+
+	// RX1Params derives the RX1 frequency and data rate from the uplink's
+	// frequency and data rate.
+	RX1Params(uplinkFreqHz uint32, uplinkDR int) (freqHz uint32, dr int)
+
+	// RX2Params returns the fixed RX2 frequency, spreading factor, and
+	// bandwidth used for join accepts and as the RX2 fallback for downlinks.
+	RX2Params() (freqHz uint32, sf int, bandwidth byte)
+
+	// TXPowerTable returns the allowed TX power levels in dBm, indexed by the
+	// region's TXPower field.
+	TXPowerTable() []int
+
+	// DefaultChannelMask returns the default enabled/disabled state of each
+	// channel in the region's channel plan.
+	DefaultChannelMask() []bool
+}
+
+// as923Params implements RegionalParameters for AS923. These are the values
+// this gateway originally hard-coded before regions were made configurable.
+type as923Params struct{}
+
+func (as923Params) Name() string                                  { return "AS923" }
+func (as923Params) JoinAcceptCFList() []byte                      { return nil }
+func (as923Params) RX1Params(freqHz uint32, dr int) (uint32, int) { return freqHz, dr }
+func (as923Params) RX2Params() (uint32, int, byte)                { return 923300000, 12, 0x06 }
+func (as923Params) TXPowerTable() []int {
+	return []int{16, 14, 12, 10, 8, 6, 4, 2}
+}
+func (as923Params) DefaultChannelMask() []bool {
+	return []bool{true, true}
+}
+
+// us915Params implements RegionalParameters for US915.
+type us915Params struct{}
+
+func (us915Params) Name() string             { return "US915" }
+func (us915Params) JoinAcceptCFList() []byte { return nil }
+func (us915Params) RX1Params(freqHz uint32, dr int) (uint32, int) {
+	// US915 RX1 uses a fixed 500 kHz downlink channel plan derived from the
+	// uplink channel number; without per-uplink channel tracking we fall back
+	// to the RX2 channel.
+	freq, _, _ := us915Params{}.RX2Params()
+	return freq, dr
+}
+
+// RX2Params returns DR8 (SF12/BW500), the fixed US915 RX2 data rate.
+func (us915Params) RX2Params() (uint32, int, byte) { return 923300000, 12, 0x06 }
+func (us915Params) TXPowerTable() []int {
+	return []int{30, 28, 26, 24, 22, 20, 18, 16, 14, 12}
+}
+func (us915Params) DefaultChannelMask() []bool {
+	mask := make([]bool, 72)
+	for i := 0; i < 64; i++ {
+		mask[i] = true
+	}
+	return mask
+}
+
+// eu868Params implements RegionalParameters for EU868.
+type eu868Params struct{}
+
+func (eu868Params) Name() string { return "EU868" }
+func (eu868Params) JoinAcceptCFList() []byte {
+	// 5 additional 3-byte channel frequencies (divided by 100), plus a
+	// reserved trailing byte, as defined by the EU868 CFListType 0.
+	cfList := make([]byte, 16)
+	freqs := []uint32{867100000, 867300000, 867500000, 867700000, 867900000}
+	for i, freq := range freqs {
+		v := freq / 100
+		cfList[i*3] = byte(v)
+		cfList[i*3+1] = byte(v >> 8)
+		cfList[i*3+2] = byte(v >> 16)
+	}
+	return cfList
+}
+func (eu868Params) RX1Params(freqHz uint32, dr int) (uint32, int) { return freqHz, dr }
+func (eu868Params) RX2Params() (uint32, int, byte)                { return 869525000, 12, 0x04 }
+func (eu868Params) TXPowerTable() []int {
+	return []int{16, 14, 12, 10, 8, 6, 4, 2}
+}
+func (eu868Params) DefaultChannelMask() []bool {
+	return []bool{true, true, true}
+}
+
+// au915Params implements RegionalParameters for AU915.
+type au915Params struct{}
+
+func (au915Params) Name() string             { return "AU915" }
+func (au915Params) JoinAcceptCFList() []byte { return nil }
+func (au915Params) RX1Params(freqHz uint32, dr int) (uint32, int) {
+	freq, _, _ := au915Params{}.RX2Params()
+	return freq, dr
+}
+
+// RX2Params returns DR8 (SF12/BW500), the fixed AU915 RX2 data rate.
+func (au915Params) RX2Params() (uint32, int, byte) { return 923300000, 12, 0x06 }
+func (au915Params) TXPowerTable() []int {
+	return []int{30, 28, 26, 24, 22, 20, 18, 16, 14, 12}
+}
+func (au915Params) DefaultChannelMask() []bool {
+	mask := make([]bool, 72)
+	for i := 0; i < 64; i++ {
+		mask[i] = true
+	}
+	return mask
+}
+
+// in865Params implements RegionalParameters for IN865.
+type in865Params struct{}
+
+func (in865Params) Name() string                                  { return "IN865" }
+func (in865Params) JoinAcceptCFList() []byte                      { return nil }
+func (in865Params) RX1Params(freqHz uint32, dr int) (uint32, int) { return freqHz, dr }
+func (in865Params) RX2Params() (uint32, int, byte)                { return 866550000, 10, 0x04 }
+func (in865Params) TXPowerTable() []int {
+	return []int{30, 28, 26, 24, 22, 20}
+}
+func (in865Params) DefaultChannelMask() []bool {
+	return []bool{true, true, true}
+}
+
+// RegionFromName looks up a RegionalParameters implementation by name.
+// Defaults to AS923 - the region this gateway originally hard-coded - when
+// name is empty.
+func RegionFromName(name string) (RegionalParameters, error) {
+	switch name {
+	case "AS923", "":
+		return as923Params{}, nil
+	case "US915":
+		return us915Params{}, nil
+	case "EU868":
+		return eu868Params{}, nil
+	case "AU915":
+		return au915Params{}, nil
+	case "IN865":
+		return in865Params{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported region %q", name)
+	}
+}
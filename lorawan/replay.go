@@ -0,0 +1,61 @@
+package lorawan
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ReplayWindowSize is the number of trailing frame counters tracked per
+// device, modeled on WireGuard's sliding-window anti-replay filter.
+const ReplayWindowSize = 64
+
+// ErrReplayedFrame is returned when an uplink's frame counter falls outside
+// the replay window or has already been seen.
+var ErrReplayedFrame = errors.New("uplink frame counter replay detected")
+
+// CheckReplay validates fCnt16, the on-air 16-bit frame counter, against the
+// device's 32-bit FCntUp and ReplayBitmap sliding window. It expands fCnt16 to
+// its full 32-bit value - handling the 16-bit rollover - and returns it on
+// success, advancing the window. Frames older than the window, or already
+// marked as seen within it, are rejected with ErrReplayedFrame.
+func CheckReplay(device *Device, fCnt16 uint16) (uint32, error) {
+	expanded := expandFCntUp(device.FCntUp, fCnt16)
+
+	diff := int64(device.FCntUp) - int64(expanded)
+	switch {
+	case diff >= ReplayWindowSize:
+		return 0, fmt.Errorf("FCnt %d is older than the replay window: %w", expanded, ErrReplayedFrame)
+	case diff >= 0:
+		bit := uint64(1) << uint(diff)
+		if device.ReplayBitmap&bit != 0 {
+			return 0, fmt.Errorf("FCnt %d already received: %w", expanded, ErrReplayedFrame)
+		}
+		device.ReplayBitmap |= bit
+	default:
+		shift := uint(-diff)
+		if shift >= ReplayWindowSize {
+			device.ReplayBitmap = 0
+		} else {
+			device.ReplayBitmap <<= shift
+		}
+		device.ReplayBitmap |= 1
+		device.FCntUp = expanded
+	}
+
+	return expanded, nil
+}
+
+// expandFCntUp reconstructs the full 32-bit frame counter from the 16-bit
+// on-air value, given the device's last known 32-bit counter. If the received
+// low 16 bits are more than 2^15 below the stored low 16 bits, a 16-bit
+// rollover is assumed and the high 16 bits are incremented.
+func expandFCntUp(stored uint32, fCnt16 uint16) uint32 {
+	storedLow := uint16(stored)
+	storedHigh := stored &^ 0xFFFF
+
+	if storedLow > fCnt16 && storedLow-fCnt16 > 1<<15 {
+		return storedHigh + 0x10000 + uint32(fCnt16)
+	}
+
+	return storedHigh + uint32(fCnt16)
+}
@@ -0,0 +1,107 @@
+package lorawan
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckReplay_InOrder(t *testing.T) {
+	device := &Device{}
+
+	for want := uint32(0); want < 5; want++ {
+		got, err := CheckReplay(device, uint16(want))
+		if err != nil {
+			t.Fatalf("CheckReplay(%d): unexpected error: %s", want, err)
+		}
+		if got != want {
+			t.Fatalf("CheckReplay(%d): got expanded %d, want %d", want, got, want)
+		}
+	}
+
+	if device.FCntUp != 4 {
+		t.Fatalf("FCntUp = %d, want 4", device.FCntUp)
+	}
+}
+
+func TestCheckReplay_OutOfOrderWithinWindow(t *testing.T) {
+	device := &Device{}
+
+	if _, err := CheckReplay(device, 10); err != nil {
+		t.Fatalf("CheckReplay(10): unexpected error: %s", err)
+	}
+
+	// 8 is behind the current counter (10) but still inside the window, and
+	// hasn't been seen yet, so it should be accepted without advancing FCntUp.
+	got, err := CheckReplay(device, 8)
+	if err != nil {
+		t.Fatalf("CheckReplay(8): unexpected error: %s", err)
+	}
+	if got != 8 {
+		t.Fatalf("CheckReplay(8): got expanded %d, want 8", got)
+	}
+	if device.FCntUp != 10 {
+		t.Fatalf("FCntUp = %d, want unchanged at 10", device.FCntUp)
+	}
+}
+
+func TestCheckReplay_Duplicate(t *testing.T) {
+	device := &Device{}
+
+	if _, err := CheckReplay(device, 5); err != nil {
+		t.Fatalf("CheckReplay(5): unexpected error: %s", err)
+	}
+
+	if _, err := CheckReplay(device, 5); !errors.Is(err, ErrReplayedFrame) {
+		t.Fatalf("CheckReplay(5) replayed: got err %v, want ErrReplayedFrame", err)
+	}
+}
+
+func TestCheckReplay_OlderThanWindow(t *testing.T) {
+	device := &Device{FCntUp: ReplayWindowSize + 10}
+
+	if _, err := CheckReplay(device, 5); !errors.Is(err, ErrReplayedFrame) {
+		t.Fatalf("CheckReplay(5): got err %v, want ErrReplayedFrame", err)
+	}
+}
+
+func TestCheckReplay_WrappedCounter(t *testing.T) {
+	// FCntUp is near the top of its 16-bit low word; the next on-air fCnt16
+	// rolls over to a small value, which should expand into the next 32-bit
+	// epoch rather than be rejected as older-than-window.
+	device := &Device{FCntUp: 0xFFFE}
+
+	want := uint32(0x10002)
+	got, err := CheckReplay(device, 2)
+	if err != nil {
+		t.Fatalf("CheckReplay(2) after rollover: unexpected error: %s", err)
+	}
+	if got != want {
+		t.Fatalf("CheckReplay(2) after rollover: got expanded %d, want %d", got, want)
+	}
+	if device.FCntUp != want {
+		t.Fatalf("FCntUp = %d, want %d", device.FCntUp, want)
+	}
+}
+
+func TestExpandFCntUp(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored uint32
+		fCnt16 uint16
+		want   uint32
+	}{
+		{"same epoch, no rollover", 100, 105, 105},
+		{"high word preserved", 0x20000 + 50, 60, 0x20000 + 60},
+		{"rollover detected", 0xFFFE, 2, 0x10002},
+		{"small backward step is not a rollover", 100, 95, 95},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := expandFCntUp(tc.stored, tc.fCnt16)
+			if got != tc.want {
+				t.Fatalf("expandFCntUp(%d, %d) = %d, want %d", tc.stored, tc.fCnt16, got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Package session persists LoRaWAN device session state across restarts, so
+// OTAA devices don't have to rejoin and ABP frame counters aren't reset every
+// time the module restarts.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"go.thethings.network/lorawan-stack/v3/pkg/types"
+)
+
+// DefaultPath is used when a Config does not set session_path.
+const DefaultPath = "gateway_sessions.json"
+
+// FlushInterval is how often in-memory counter updates should be flushed to
+// the Store.
+const FlushInterval = 30 * time.Second
+
+// State is the persisted state for a single device, keyed by its name in
+// the store.
+type State struct {
+	DevAddr    []byte          `json:"dev_addr"`
+	AppSKey    types.AES128Key `json:"app_s_key"`
+	NwkSKey    types.AES128Key `json:"network_s_key"`
+	FCntUp     uint32          `json:"f_cnt_up"`
+	FCntDown   uint32          `json:"f_cnt_down"`
+	JoinNonces []uint32        `json:"join_nonces"`
+	LastSeen   time.Time       `json:"last_seen"`
+}
+
+// Store persists per-device session state across restarts.
+type Store interface {
+	// Load returns all persisted sessions, keyed by device name.
+	Load() (map[string]State, error)
+	// Save persists the session state for a single device.
+	Save(deviceName string, state State) error
+	// Close flushes any buffered state and releases the store's resources.
+	Close() error
+}
+
+// fileStore is the default Store, backed by a single JSON file.
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+	data map[string]State
+}
+
+// NewFileStore opens (or creates) a JSON-file-backed Store at path.
+func NewFileStore(path string) (Store, error) {
+	s := &fileStore{
+		path: path,
+		data: make(map[string]State),
+	}
+
+	raw, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return s, nil
+	case err != nil:
+		return nil, fmt.Errorf("failed to read session store %s: %w", path, err)
+	}
+
+	if len(raw) == 0 {
+		return s, nil
+	}
+
+	if err := json.Unmarshal(raw, &s.data); err != nil {
+		return nil, fmt.Errorf("failed to parse session store %s: %w", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *fileStore) Load() (map[string]State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]State, len(s.data))
+	for name, state := range s.data {
+		out[name] = state
+	}
+	return out, nil
+}
+
+func (s *fileStore) Save(deviceName string, state State) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data[deviceName] = state
+	raw, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal session store: %w", err)
+	}
+
+	// Write to a temp file and rename over s.path so a crash mid-write can't
+	// leave a truncated or corrupted session store behind. The lock is held
+	// across the write+rename, since two concurrent saves racing to the same
+	// temp path (or renaming out of order) could persist a stale snapshot -
+	// rewinding FCntUp/the replay window on restart.
+	tmpPath := s.path + ".tmp"
+	if err := os.WriteFile(tmpPath, raw, 0o600); err != nil {
+		return fmt.Errorf("failed to write session store %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, s.path); err != nil {
+		return fmt.Errorf("failed to replace session store %s: %w", s.path, err)
+	}
+	return nil
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}